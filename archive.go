@@ -0,0 +1,396 @@
+package toolkit
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ServeArchive streams everything under root as a single archive, without
+// buffering it in memory first. format is one of "zip", "tar" or "tar.gz".
+// name is used as the archive's Content-Disposition filename.
+func (t *Tools) ServeArchive(w http.ResponseWriter, r *http.Request, root, format, name string) error {
+	switch format {
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		return writeZipArchive(w, root)
+	case "tar":
+		w.Header().Set("Content-Type", "application/x-tar")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		return writeTarArchive(w, root, false)
+	case "tar.gz":
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+		return writeTarArchive(w, root, true)
+	default:
+		return fmt.Errorf("unsupported archive format %q", format)
+	}
+}
+
+func writeZipArchive(w io.Writer, root string) error {
+	zw := zip.NewWriter(w)
+	defer zw.Close()
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		hdr.Method = zip.Deflate
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(fw, f)
+		return err
+	})
+}
+
+func writeTarArchive(w io.Writer, root string, gzipped bool) error {
+	out := w
+	if gzipped {
+		gzw := gzip.NewWriter(w)
+		defer gzw.Close()
+		out = gzw
+	}
+
+	tw := tar.NewWriter(out)
+	defer tw.Close()
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// isArchiveName reports whether name has a file extension ExtractArchive
+// knows how to expand.
+func isArchiveName(name string) bool {
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return true
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return true
+	case strings.HasSuffix(name, ".tar"):
+		return true
+	default:
+		return false
+	}
+}
+
+// runArchiveExtraction expands the just-uploaded archive named
+// uploadedFile.NewFileName into uploadDir, recording the results as
+// uploadedFile.Derivatives. It only runs against a LocalFSStorage, since
+// ExtractArchive needs a local path to read the archive back from.
+func (t *Tools) runArchiveExtraction(storage Storage, uploadDir string, uploadedFile *UploadedFile) error {
+	local, ok := storage.(*LocalFSStorage)
+	if !ok {
+		return nil
+	}
+
+	src := filepath.Join(local.RootDir, uploadedFile.NewFileName)
+
+	extracted, err := t.ExtractArchive(src, uploadDir, t.ArchiveExtractOptions)
+	if err != nil {
+		return err
+	}
+
+	uploadedFile.Derivatives = extracted
+
+	return nil
+}
+
+// ExtractOptions configures Tools.ExtractArchive.
+type ExtractOptions struct {
+	// MaxFiles caps the number of entries ExtractArchive will write. 0
+	// means no limit.
+	MaxFiles int
+
+	// MaxTotalBytes caps the combined size of every extracted file. 0
+	// means no limit.
+	MaxTotalBytes int64
+
+	// AllowedFileTypes, if non-empty, restricts extraction to files whose
+	// sniffed content type matches one of these entries, the same way
+	// Tools.AllowedFileTypes restricts UploadFiles.
+	AllowedFileTypes []string
+}
+
+// ExtractArchive safely expands the zip, tar or tar.gz archive at src into
+// destDir, which is created if it doesn't already exist. Entries whose path
+// would escape destDir ("zip slip") are rejected, along with anything that
+// isn't a regular file or directory (symlinks, devices, ...). It returns an
+// UploadedFile for each entry written.
+func (t *Tools) ExtractArchive(src, destDir string, opts ExtractOptions) ([]UploadedFile, error) {
+	if err := t.CreateDirIfNotExists(destDir); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(src, ".zip"):
+		return t.extractZip(src, destDir, opts)
+	case strings.HasSuffix(src, ".tar.gz") || strings.HasSuffix(src, ".tgz"):
+		return t.extractTar(src, destDir, opts, true)
+	case strings.HasSuffix(src, ".tar"):
+		return t.extractTar(src, destDir, opts, false)
+	default:
+		return nil, fmt.Errorf("unrecognized archive extension for %q", src)
+	}
+}
+
+func (t *Tools) extractZip(src, destDir string, opts ExtractOptions) ([]UploadedFile, error) {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var extracted []UploadedFile
+	var totalBytes int64
+
+	for _, entry := range zr.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		destPath, err := safeJoin(destDir, entry.Name)
+		if err != nil {
+			return extracted, err
+		}
+
+		if opts.MaxFiles > 0 && len(extracted) >= opts.MaxFiles {
+			return extracted, fmt.Errorf("archive contains more than the %d allowed files", opts.MaxFiles)
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return extracted, err
+		}
+
+		file, n, err := writeExtractedFile(rc, destPath, entry.Name, opts, opts.remainingBytes(totalBytes))
+		rc.Close()
+		totalBytes += n
+		if err != nil {
+			return extracted, err
+		}
+
+		extracted = append(extracted, file)
+	}
+
+	return extracted, nil
+}
+
+func (t *Tools) extractTar(src, destDir string, opts ExtractOptions, gzipped bool) ([]UploadedFile, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if gzipped {
+		gzr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	tr := tar.NewReader(r)
+
+	var extracted []UploadedFile
+	var totalBytes int64
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		destPath, err := safeJoin(destDir, hdr.Name)
+		if err != nil {
+			return extracted, err
+		}
+
+		if opts.MaxFiles > 0 && len(extracted) >= opts.MaxFiles {
+			return extracted, fmt.Errorf("archive contains more than the %d allowed files", opts.MaxFiles)
+		}
+
+		file, n, err := writeExtractedFile(tr, destPath, hdr.Name, opts, opts.remainingBytes(totalBytes))
+		totalBytes += n
+		if err != nil {
+			return extracted, err
+		}
+
+		extracted = append(extracted, file)
+	}
+
+	return extracted, nil
+}
+
+// remainingBytes returns how many more bytes may be written before
+// totalBytesSoFar would exceed MaxTotalBytes, or -1 if MaxTotalBytes is
+// unset (no limit).
+func (o ExtractOptions) remainingBytes(totalBytesSoFar int64) int64 {
+	if o.MaxTotalBytes <= 0 {
+		return -1
+	}
+	return o.MaxTotalBytes - totalBytesSoFar
+}
+
+// safeJoin joins destDir and name, rejecting the result if it would escape
+// destDir - the "zip slip" vulnerability where a malicious archive entry
+// name like "../../etc/passwd" writes outside the intended directory.
+func safeJoin(destDir, name string) (string, error) {
+	destPath := filepath.Join(destDir, name)
+
+	destDirAbs, err := filepath.Abs(destDir)
+	if err != nil {
+		return "", err
+	}
+	destPathAbs, err := filepath.Abs(destPath)
+	if err != nil {
+		return "", err
+	}
+
+	if destPathAbs != destDirAbs && !strings.HasPrefix(destPathAbs, destDirAbs+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %q escapes the destination directory", name)
+	}
+
+	return destPath, nil
+}
+
+// writeExtractedFile reads r to completion, enforcing opts.AllowedFileTypes
+// against the first 512 bytes, and writes it to destPath, creating any
+// missing parent directories. remaining is the number of bytes still
+// available under opts.MaxTotalBytes (-1 if that limit is unset); it bounds
+// how much of r is ever copied to disk, so a single entry that decompresses
+// far beyond the archive's stated size can't exhaust disk before the
+// aggregate limit is checked. It returns the UploadedFile describing the
+// entry and the number of bytes written, even on error.
+func writeExtractedFile(r io.Reader, destPath, originalName string, opts ExtractOptions, remaining int64) (UploadedFile, int64, error) {
+	var t Tools
+	if err := t.CreateDirIfNotExists(filepath.Dir(destPath)); err != nil {
+		return UploadedFile{}, 0, err
+	}
+
+	if remaining >= 0 {
+		r = io.LimitReader(r, remaining+1)
+	}
+
+	buff := make([]byte, 512)
+	n, err := io.ReadFull(r, buff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return UploadedFile{}, 0, err
+	}
+	buff = buff[:n]
+
+	if len(opts.AllowedFileTypes) > 0 {
+		fileType := http.DetectContentType(buff)
+		allowed := false
+		for _, x := range opts.AllowedFileTypes {
+			if strings.EqualFold(fileType, x) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return UploadedFile{}, 0, fmt.Errorf("archive entry %q has a file type that is not permitted", originalName)
+		}
+	}
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return UploadedFile{}, 0, err
+	}
+	defer out.Close()
+
+	written, err := out.Write(buff)
+	if err != nil {
+		return UploadedFile{}, 0, err
+	}
+
+	rest, err := io.Copy(out, r)
+	if err != nil {
+		return UploadedFile{}, 0, err
+	}
+
+	size := int64(written) + rest
+
+	if remaining >= 0 && size > remaining {
+		return UploadedFile{}, size, fmt.Errorf("archive exceeds the %d byte limit", opts.MaxTotalBytes)
+	}
+
+	return UploadedFile{
+		NewFileName:      filepath.Base(destPath),
+		OriginalFileName: originalName,
+		FileSize:         size,
+	}, size, nil
+}
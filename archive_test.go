@@ -0,0 +1,326 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTools_ServeArchive_Zip(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/archive.zip", nil)
+
+	if err := testTools.ServeArchive(rr, req, root, "zip", "archive.zip"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rr.Header().Get("Content-Type"); got != "application/zip" {
+		t.Errorf("wrong Content-Type: %s", got)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	if !names["a.txt"] || !names["sub/b.txt"] {
+		t.Errorf("expected a.txt and sub/b.txt in archive, got %v", names)
+	}
+}
+
+func TestTools_ExtractArchive_Zip(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "bundle.zip")
+
+	func() {
+		f, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		zw := zip.NewWriter(f)
+		w, err := zw.Create("one.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("one")); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	destDir := filepath.Join(root, "out")
+	var testTools Tools
+
+	files, err := testTools.ExtractArchive(archivePath, destDir, ExtractOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected 1 extracted file, got %d", len(files))
+	}
+
+	data, err := os.ReadFile(filepath.Join(destDir, "one.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one" {
+		t.Errorf("expected extracted contents %q, got %q", "one", string(data))
+	}
+}
+
+func TestTools_ExtractArchive_RejectsZipSlip(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "evil.zip")
+
+	func() {
+		f, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		zw := zip.NewWriter(f)
+		w, err := zw.Create("../../etc/passwd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte("nope")); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	destDir := filepath.Join(root, "out")
+	var testTools Tools
+
+	if _, err := testTools.ExtractArchive(archivePath, destDir, ExtractOptions{}); err == nil {
+		t.Error("expected an error for an archive entry that escapes the destination directory")
+	}
+}
+
+func TestTools_ExtractArchive_MaxFiles(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "bundle.zip")
+
+	func() {
+		f, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		zw := zip.NewWriter(f)
+		for _, name := range []string{"one.txt", "two.txt"} {
+			w, err := zw.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write([]byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	destDir := filepath.Join(root, "out")
+	var testTools Tools
+
+	if _, err := testTools.ExtractArchive(archivePath, destDir, ExtractOptions{MaxFiles: 1}); err == nil {
+		t.Error("expected an error when the archive exceeds MaxFiles")
+	}
+}
+
+func TestTools_ExtractArchive_MaxTotalBytesBoundsTheCopy(t *testing.T) {
+	root := t.TempDir()
+	archivePath := filepath.Join(root, "bundle.zip")
+
+	large := bytes.Repeat([]byte("x"), 2*1024*1024)
+
+	func() {
+		f, err := os.Create(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		zw := zip.NewWriter(f)
+		w, err := zw.Create("big.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(large); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	destDir := filepath.Join(root, "out")
+	var testTools Tools
+
+	if _, err := testTools.ExtractArchive(archivePath, destDir, ExtractOptions{MaxTotalBytes: 1024}); err == nil {
+		t.Fatal("expected an error when the entry exceeds MaxTotalBytes")
+	}
+
+	info, err := os.Stat(filepath.Join(destDir, "big.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 1025 {
+		t.Errorf("expected the copy itself to be bounded near the 1024 byte limit, but %d bytes were written to disk", info.Size())
+	}
+}
+
+func TestTools_UploadFiles_ExtractArchives(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	defer os.RemoveAll(uploadDir)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, err := zw.Create("inner.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("inner contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var testTools Tools
+	testTools.ExtractArchives = true
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer writer.Close()
+		defer wg.Done()
+
+		part, err := writer.CreateFormFile("file", "bundle.zip")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := part.Write(archive.Bytes()); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	request := httptest.NewRequest("POST", "/", pr)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	files, err := testTools.UploadFiles(request, uploadDir, true)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files[0].Derivatives) != 1 {
+		t.Fatalf("expected 1 derivative from extraction, got %d", len(files[0].Derivatives))
+	}
+
+	data, err := os.ReadFile(filepath.Join(uploadDir, files[0].Derivatives[0].NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "inner contents" {
+		t.Errorf("expected extracted contents %q, got %q", "inner contents", string(data))
+	}
+}
+
+func TestTools_UploadFiles_ExtractArchives_CleansUpOnFailure(t *testing.T) {
+	uploadDir := "./testdata/uploads-archive-cleanup"
+	defer os.RemoveAll(uploadDir)
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, name := range []string{"one.txt", "two.txt"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var testTools Tools
+	testTools.ExtractArchives = true
+	testTools.ArchiveExtractOptions = ExtractOptions{MaxFiles: 1}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer writer.Close()
+		defer wg.Done()
+
+		part, err := writer.CreateFormFile("file", "bundle.zip")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := part.Write(archive.Bytes()); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	request := httptest.NewRequest("POST", "/", pr)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	_, uploadErr := testTools.UploadFiles(request, uploadDir, true)
+	wg.Wait()
+	if uploadErr == nil {
+		t.Fatal("expected an error when the archive exceeds MaxFiles")
+	}
+
+	entries, readErr := os.ReadDir(uploadDir)
+	if readErr == nil {
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".zip") {
+				t.Errorf("expected the rejected archive's blob to be cleaned up, found %s", entry.Name())
+			}
+		}
+	}
+}
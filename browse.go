@@ -0,0 +1,229 @@
+package toolkit
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BrowseOptions configures Tools.BrowseDirectory.
+type BrowseOptions struct {
+	// IgnoreIndexes disables the usual behavior of serving index.html or
+	// index.htm instead of a directory listing when one is present.
+	IgnoreIndexes bool
+
+	// Template renders the directory listing as HTML. If nil,
+	// defaultBrowseTemplate is used.
+	Template *template.Template
+
+	// HiddenFileGlobs lists path.Match-style globs; any entry whose name
+	// matches one of them is omitted from the listing.
+	HiddenFileGlobs []string
+}
+
+// FileInfo describes a single entry in a Listing.
+type FileInfo struct {
+	Name      string
+	Size      int64
+	ModTime   time.Time
+	IsDir     bool
+	URL       string
+	HumanSize string
+}
+
+// Listing is the data rendered by BrowseDirectory, either as JSON or via
+// BrowseOptions.Template.
+type Listing struct {
+	Name     string
+	Path     string
+	CanGoUp  bool
+	UpURL    string
+	Items    []FileInfo
+	NumDirs  int
+	NumFiles int
+}
+
+var defaultBrowseTemplate = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<ul>
+{{if .CanGoUp}}<li><a href="{{.UpURL}}">..</a></li>{{end}}
+{{range .Items}}<li><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a> - {{.HumanSize}} - {{.ModTime.Format "2006-01-02 15:04:05"}}</li>
+{{end}}
+</ul>
+<p>{{.NumDirs}} directories, {{.NumFiles}} files</p>
+</body>
+</html>
+`))
+
+// BrowseDirectory renders a directory listing for the path in r.URL,
+// relative to urlPrefix, served out of root. It is meant to sit beside
+// DownloadStaticFiles/ServeStaticFile: point it at the same http.FileSystem
+// and it takes over whenever the requested path is a directory. The listing
+// can be sorted and paginated with the query parameters
+// ?sort=name|size|time, ?order=asc|desc and ?limit=N, and is returned as
+// JSON when the request's Accept header asks for application/json, or HTML
+// (via BrowseOptions.Template) otherwise.
+func (t *Tools) BrowseDirectory(w http.ResponseWriter, r *http.Request, root http.FileSystem, urlPrefix string, opts BrowseOptions) error {
+	upath := strings.TrimPrefix(r.URL.Path, urlPrefix)
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+	}
+
+	if !opts.IgnoreIndexes {
+		for _, index := range []string{"index.html", "index.htm"} {
+			if served, err := t.serveIndexIfExists(w, r, root, path.Join(upath, index)); err != nil {
+				return err
+			} else if served {
+				return nil
+			}
+		}
+	}
+
+	dir, err := root.Open(upath)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	info, err := dir.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", upath)
+	}
+
+	entries, err := dir.Readdir(-1)
+	if err != nil {
+		return err
+	}
+
+	listing := Listing{
+		Name:    path.Base(upath),
+		Path:    upath,
+		CanGoUp: upath != "/",
+	}
+	if listing.CanGoUp {
+		listing.UpURL = path.Join(urlPrefix, path.Dir(strings.TrimSuffix(upath, "/")))
+	}
+
+	for _, entry := range entries {
+		if matchesAnyGlob(opts.HiddenFileGlobs, entry.Name()) {
+			continue
+		}
+
+		item := FileInfo{
+			Name:      entry.Name(),
+			Size:      entry.Size(),
+			ModTime:   entry.ModTime(),
+			IsDir:     entry.IsDir(),
+			URL:       path.Join(urlPrefix, upath, entry.Name()),
+			HumanSize: humanizeBytes(entry.Size()),
+		}
+
+		if item.IsDir {
+			listing.NumDirs++
+			item.URL += "/"
+		} else {
+			listing.NumFiles++
+		}
+
+		listing.Items = append(listing.Items, item)
+	}
+
+	sortListing(listing.Items, r.URL.Query().Get("sort"), r.URL.Query().Get("order"))
+
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		if n, err := strconv.Atoi(limit); err == nil && n >= 0 && n < len(listing.Items) {
+			listing.Items = listing.Items[:n]
+		}
+	}
+
+	if wantsJSON(r) {
+		return t.WriteJSON(w, http.StatusOK, listing)
+	}
+
+	tmpl := opts.Template
+	if tmpl == nil {
+		tmpl = defaultBrowseTemplate
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return tmpl.Execute(w, listing)
+}
+
+// serveIndexIfExists serves indexPath through ServeContent if it exists in
+// root, reporting whether it did so.
+func (t *Tools) serveIndexIfExists(w http.ResponseWriter, r *http.Request, root http.FileSystem, indexPath string) (bool, error) {
+	f, err := root.Open(indexPath)
+	if err != nil {
+		return false, nil
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		return false, nil
+	}
+
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+
+	return true, nil
+}
+
+func matchesAnyGlob(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func sortListing(items []FileInfo, sortBy, order string) {
+	var less func(i, j int) bool
+	switch sortBy {
+	case "size":
+		less = func(i, j int) bool { return items[i].Size < items[j].Size }
+	case "time":
+		less = func(i, j int) bool { return items[i].ModTime.Before(items[j].ModTime) }
+	default:
+		less = func(i, j int) bool { return strings.ToLower(items[i].Name) < strings.ToLower(items[j].Name) }
+	}
+
+	sort.SliceStable(items, less)
+
+	if order == "desc" {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+}
+
+// humanizeBytes renders n as a human-readable size, e.g. "1.5 MiB".
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for k := n / unit; k >= unit; k /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
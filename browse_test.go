@@ -0,0 +1,112 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTools_BrowseDirectory_HTML(t *testing.T) {
+	var testTools Tools
+	root := http.Dir("./testdata/browse")
+
+	req := httptest.NewRequest("GET", "/files/", nil)
+	rr := httptest.NewRecorder()
+
+	err := testTools.BrowseDirectory(rr, req, root, "/files", BrowseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := rr.Result()
+	if !strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("expected HTML content type, got %s", res.Header.Get("Content-Type"))
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "a.txt") || !strings.Contains(body, "b.txt") || !strings.Contains(body, "subdir") {
+		t.Errorf("expected listing to mention all entries, got: %s", body)
+	}
+}
+
+func TestTools_BrowseDirectory_JSON(t *testing.T) {
+	var testTools Tools
+	root := http.Dir("./testdata/browse")
+
+	req := httptest.NewRequest("GET", "/files/", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+
+	err := testTools.BrowseDirectory(rr, req, root, "/files", BrowseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listing Listing
+	if err := json.NewDecoder(rr.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+
+	if listing.NumDirs != 1 || listing.NumFiles != 2 {
+		t.Errorf("expected 1 dir and 2 files, got %d dirs and %d files", listing.NumDirs, listing.NumFiles)
+	}
+}
+
+func TestTools_BrowseDirectory_SortBySizeDesc(t *testing.T) {
+	var testTools Tools
+	root := http.Dir("./testdata/browse")
+
+	req := httptest.NewRequest("GET", "/files/?sort=size&order=desc", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+
+	err := testTools.BrowseDirectory(rr, req, root, "/files", BrowseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listing Listing
+	if err := json.NewDecoder(rr.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+
+	var fileSizes []int64
+	for _, item := range listing.Items {
+		if !item.IsDir {
+			fileSizes = append(fileSizes, item.Size)
+		}
+	}
+
+	for i := 1; i < len(fileSizes); i++ {
+		if fileSizes[i] > fileSizes[i-1] {
+			t.Errorf("items not sorted by size descending: %v", fileSizes)
+		}
+	}
+}
+
+func TestTools_BrowseDirectory_HiddenGlob(t *testing.T) {
+	var testTools Tools
+	root := http.Dir("./testdata/browse")
+
+	req := httptest.NewRequest("GET", "/files/", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+
+	err := testTools.BrowseDirectory(rr, req, root, "/files", BrowseOptions{HiddenFileGlobs: []string{"a.*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var listing Listing
+	if err := json.NewDecoder(rr.Body).Decode(&listing); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range listing.Items {
+		if item.Name == "a.txt" {
+			t.Error("expected a.txt to be hidden")
+		}
+	}
+}
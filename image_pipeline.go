@@ -0,0 +1,317 @@
+package toolkit
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+func init() {
+	image.RegisterFormat("webp", "RIFF????WEBP", webp.Decode, webp.DecodeConfig)
+}
+
+// defaultMaxImagePixels is the pixel-area ceiling used when
+// Tools.MaxImagePixels is left at its zero value.
+const defaultMaxImagePixels = 10_000_000
+
+// ImageOp is one step of a Tools.ImagePipeline. The concrete types are
+// ResizeOp, ThumbnailOp, ReencodeOp and StripMetadataOp.
+type ImageOp interface {
+	isImageOp()
+}
+
+// ResizeOp scales the uploaded image down so it fits within
+// MaxWidth x MaxHeight, replacing it in place. If Preserve is true, the
+// aspect ratio is kept and the image is only ever shrunk, never stretched.
+type ResizeOp struct {
+	MaxWidth  int
+	MaxHeight int
+	Preserve  bool
+}
+
+func (ResizeOp) isImageOp() {}
+
+// ThumbnailOp writes an additional, smaller copy of the image alongside the
+// original; it never modifies the original itself. The derivative's name is
+// the original's with Suffix inserted before its extension (default
+// "_thumb" if Suffix is empty).
+type ThumbnailOp struct {
+	Width  int
+	Height int
+	Suffix string
+}
+
+func (ThumbnailOp) isImageOp() {}
+
+// ReencodeOp changes the format (and, for JPEG, quality) the image is saved
+// in. Format is one of "jpeg", "png" or "gif".
+type ReencodeOp struct {
+	Format  string
+	Quality int
+}
+
+func (ReencodeOp) isImageOp() {}
+
+// StripMetadataOp forces the image to be re-encoded even if no other op in
+// the pipeline would have changed it, which drops any EXIF/ICC data Go's
+// image codecs don't round-trip.
+type StripMetadataOp struct{}
+
+func (StripMetadataOp) isImageOp() {}
+
+// imagePipelineState threads the working image and its target encoding
+// through a Tools.ImagePipeline.
+type imagePipelineState struct {
+	img     image.Image
+	format  string
+	quality int
+	changed bool
+}
+
+// runImagePipeline decodes the image behind infile, runs it through
+// t.ImagePipeline, writes back the transformed original (if the pipeline
+// changed it) and any thumbnails, and updates uploadedFile accordingly. It
+// is a no-op when no pipeline is configured.
+func (t *Tools) runImagePipeline(storage Storage, infile io.ReadSeeker, uploadedFile *UploadedFile) error {
+	if len(t.ImagePipeline) == 0 {
+		return nil
+	}
+
+	if _, err := infile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	cfg, format, err := image.DecodeConfig(infile)
+	if err != nil {
+		// The sniffed content type said "image/...", but it isn't one of
+		// the formats we can decode (e.g. SVG). Leave the upload as-is.
+		return nil
+	}
+
+	maxPixels := t.MaxImagePixels
+	if maxPixels == 0 {
+		maxPixels = defaultMaxImagePixels
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return fmt.Errorf("image is %dx%d pixels, which exceeds the %d pixel limit", cfg.Width, cfg.Height, maxPixels)
+	}
+
+	if _, err := infile.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(infile)
+	if err != nil {
+		return err
+	}
+
+	state := &imagePipelineState{img: img, format: format, quality: 90}
+	var derivatives []UploadedFile
+
+	for _, op := range t.ImagePipeline {
+		op := op
+		err := t.withImageOpTimeout(func() error {
+			return t.applyImageOp(op, state, storage, uploadedFile, &derivatives)
+		})
+		if err != nil {
+			deleteDerivatives(storage, derivatives)
+			return err
+		}
+	}
+
+	if state.changed {
+		data, contentType, err := encodeImage(state.img, state.format, state.quality)
+		if err != nil {
+			deleteDerivatives(storage, derivatives)
+			return err
+		}
+
+		name := uploadedFile.NewFileName
+		if ext := extensionFor(state.format); ext != "" {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + ext
+		}
+
+		url, err := storage.Put(name, bytes.NewReader(data), contentType)
+		if err != nil {
+			deleteDerivatives(storage, derivatives)
+			return err
+		}
+
+		uploadedFile.NewFileName = name
+		uploadedFile.URL = url
+		uploadedFile.FileSize = int64(len(data))
+	}
+
+	uploadedFile.Derivatives = derivatives
+
+	return nil
+}
+
+func (t *Tools) withImageOpTimeout(fn func() error) error {
+	if t.ImageOpTimeout <= 0 {
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(t.ImageOpTimeout):
+		return fmt.Errorf("image operation timed out after %s", t.ImageOpTimeout)
+	}
+}
+
+func (t *Tools) applyImageOp(op ImageOp, state *imagePipelineState, storage Storage, uploadedFile *UploadedFile, derivatives *[]UploadedFile) error {
+	switch o := op.(type) {
+	case ResizeOp:
+		state.img = resizeImage(state.img, o.MaxWidth, o.MaxHeight, o.Preserve)
+		state.changed = true
+
+	case ThumbnailOp:
+		thumb := resizeImage(state.img, o.Width, o.Height, true)
+
+		data, contentType, err := encodeImage(thumb, state.format, state.quality)
+		if err != nil {
+			return err
+		}
+
+		name := thumbnailName(uploadedFile.NewFileName, o.Suffix)
+		url, err := storage.Put(name, bytes.NewReader(data), contentType)
+		if err != nil {
+			return err
+		}
+
+		*derivatives = append(*derivatives, UploadedFile{
+			NewFileName:      name,
+			OriginalFileName: uploadedFile.OriginalFileName,
+			FileSize:         int64(len(data)),
+			URL:              url,
+		})
+
+	case ReencodeOp:
+		state.format = o.Format
+		if o.Quality > 0 {
+			state.quality = o.Quality
+		}
+		state.changed = true
+
+	case StripMetadataOp:
+		state.changed = true
+
+	default:
+		return fmt.Errorf("unsupported image op %T", op)
+	}
+
+	return nil
+}
+
+// resizeImage scales src down to fit within maxWidth x maxHeight using
+// Catmull-Rom resampling. If preserve is true, the aspect ratio is kept and
+// src is only ever shrunk. A zero maxWidth or maxHeight leaves that
+// dimension unconstrained.
+func resizeImage(src image.Image, maxWidth, maxHeight int, preserve bool) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	if maxWidth <= 0 {
+		maxWidth = w
+	}
+	if maxHeight <= 0 {
+		maxHeight = h
+	}
+
+	newW, newH := maxWidth, maxHeight
+	if preserve {
+		ratio := math.Min(float64(maxWidth)/float64(w), float64(maxHeight)/float64(h))
+		if ratio < 1 {
+			newW = int(float64(w) * ratio)
+			newH = int(float64(h) * ratio)
+		} else {
+			newW, newH = w, h
+		}
+	}
+
+	if newW == w && newH == h {
+		return src
+	}
+	if newW < 1 {
+		newW = 1
+	}
+	if newH < 1 {
+		newH = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+
+	return dst
+}
+
+func encodeImage(img image.Image, format string, quality int) ([]byte, string, error) {
+	var buf bytes.Buffer
+
+	switch format {
+	case "jpeg", "jpg":
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	case "gif":
+		if err := gif.Encode(&buf, img, nil); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/gif", nil
+	case "png", "":
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/png", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported re-encode format %q", format)
+	}
+}
+
+func extensionFor(format string) string {
+	switch format {
+	case "jpeg", "jpg":
+		return ".jpg"
+	case "png":
+		return ".png"
+	case "gif":
+		return ".gif"
+	default:
+		return ""
+	}
+}
+
+// deleteDerivatives removes any derivative files already written via
+// storage.Put when the pipeline aborts partway through a later op, so a
+// failed upload doesn't leave orphaned thumbnails behind.
+func deleteDerivatives(storage Storage, derivatives []UploadedFile) {
+	for _, d := range derivatives {
+		_ = storage.Delete(d.NewFileName)
+	}
+}
+
+func thumbnailName(name, suffix string) string {
+	if suffix == "" {
+		suffix = "_thumb"
+	}
+
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	return base + suffix + ext
+}
@@ -0,0 +1,266 @@
+package toolkit
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+func uploadTestImage(t *testing.T, tools *Tools, uploadDir string) *UploadedFile {
+	t.Helper()
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer writer.Close()
+		defer wg.Done()
+
+		part, err := writer.CreateFormFile("file", "img.png")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		f, err := os.Open("./testdata/img.png")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+
+		img, _, err := image.Decode(f)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := png.Encode(part, img); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	request := httptest.NewRequest("POST", "/", pr)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	files, err := tools.UploadFiles(request, uploadDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	return files[0]
+}
+
+func TestTools_ImagePipeline_Resize(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+
+	var testTools Tools
+	testTools.ImagePipeline = []ImageOp{ResizeOp{MaxWidth: 20, MaxHeight: 20, Preserve: true}}
+
+	file := uploadTestImage(t, &testTools, uploadDir)
+	defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, file.NewFileName))
+
+	f, err := os.Open(fmt.Sprintf("%s/%s", uploadDir, file.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width > 20 || cfg.Height > 20 {
+		t.Errorf("expected resized image to fit within 20x20, got %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestTools_ImagePipeline_Thumbnail(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+
+	var testTools Tools
+	testTools.ImagePipeline = []ImageOp{ThumbnailOp{Width: 10, Height: 10, Suffix: "_thumb"}}
+
+	file := uploadTestImage(t, &testTools, uploadDir)
+	defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, file.NewFileName))
+
+	if len(file.Derivatives) != 1 {
+		t.Fatalf("expected exactly one derivative, got %d", len(file.Derivatives))
+	}
+	thumb := file.Derivatives[0]
+	defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, thumb.NewFileName))
+
+	f, err := os.Open(fmt.Sprintf("%s/%s", uploadDir, thumb.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width > 10 || cfg.Height > 10 {
+		t.Errorf("expected thumbnail to fit within 10x10, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	// the original itself should be untouched by a thumbnail-only pipeline
+	orig, err := os.Open(fmt.Sprintf("%s/%s", uploadDir, file.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orig.Close()
+
+	origCfg, _, err := image.DecodeConfig(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if origCfg.Width != 50 || origCfg.Height != 50 {
+		t.Errorf("expected original to remain 50x50, got %dx%d", origCfg.Width, origCfg.Height)
+	}
+}
+
+func TestTools_ImagePipeline_Reencode(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+
+	var testTools Tools
+	testTools.ImagePipeline = []ImageOp{ReencodeOp{Format: "jpeg", Quality: 80}}
+
+	file := uploadTestImage(t, &testTools, uploadDir)
+	defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, file.NewFileName))
+
+	if got := file.NewFileName[len(file.NewFileName)-4:]; got != ".jpg" {
+		t.Errorf("expected re-encoded file to have a .jpg extension, got %s", file.NewFileName)
+	}
+
+	f, err := os.Open(fmt.Sprintf("%s/%s", uploadDir, file.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, format, err := image.DecodeConfig(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" {
+		t.Errorf("expected jpeg, got %s", format)
+	}
+}
+
+func TestTools_ImagePipeline_CleansUpDerivativesOnLaterStepFailure(t *testing.T) {
+	uploadDir := "./testdata/uploads-pipeline-cleanup"
+	defer os.RemoveAll(uploadDir)
+
+	var testTools Tools
+	testTools.ImagePipeline = []ImageOp{
+		ThumbnailOp{Width: 10, Height: 10, Suffix: "_thumb"},
+		ReencodeOp{Format: "bogus"},
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer writer.Close()
+		defer wg.Done()
+
+		part, err := writer.CreateFormFile("file", "img.png")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		f, err := os.Open("./testdata/img.png")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+		img, _, err := image.Decode(f)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := png.Encode(part, img); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	request := httptest.NewRequest("POST", "/", pr)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	_, err := testTools.UploadFiles(request, uploadDir, true)
+	wg.Wait()
+	if err == nil {
+		t.Fatal("expected an error from the unsupported re-encode format")
+	}
+
+	entries, readErr := os.ReadDir(uploadDir)
+	if readErr == nil && len(entries) != 0 {
+		t.Errorf("expected both the original and the thumbnail to be cleaned up, found %d file(s) left in %s", len(entries), uploadDir)
+	}
+}
+
+func TestTools_ImagePipeline_MaxImagePixels(t *testing.T) {
+	uploadDir := "./testdata/uploads-maxpixels"
+
+	var testTools Tools
+	testTools.ImagePipeline = []ImageOp{ResizeOp{MaxWidth: 10, MaxHeight: 10, Preserve: true}}
+	testTools.MaxImagePixels = 100 // the 50x50 fixture has 2500 pixels
+	defer os.RemoveAll(uploadDir)
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer writer.Close()
+		defer wg.Done()
+
+		part, err := writer.CreateFormFile("file", "img.png")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		f, err := os.Open("./testdata/img.png")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+		img, _, err := image.Decode(f)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := png.Encode(part, img); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	request := httptest.NewRequest("POST", "/", pr)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	_, err := testTools.UploadFiles(request, uploadDir, true)
+	wg.Wait()
+	if err == nil {
+		t.Error("expected an error for an image exceeding MaxImagePixels")
+	}
+
+	entries, readErr := os.ReadDir(uploadDir)
+	if readErr == nil && len(entries) != 0 {
+		t.Errorf("expected the rejected upload's blob to be cleaned up, found %d file(s) left in %s", len(entries), uploadDir)
+	}
+}
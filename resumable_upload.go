@@ -0,0 +1,393 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UploadSession tracks the state of a single in-progress resumable upload,
+// modeled on the Docker distribution blob upload protocol: a client opens a
+// session with StartUpload, streams the body across one or more AppendUpload
+// (PATCH) calls, and closes it out with FinishUpload (PUT).
+type UploadSession struct {
+	UUID      string
+	StartedAt time.Time
+	Offset    int64
+	TempPath  string
+
+	hasher hash.Hash
+}
+
+// UploadSessionStore persists UploadSession state between the requests that
+// make up a single resumable upload.
+type UploadSessionStore interface {
+	Create(session *UploadSession) error
+	Get(uuid string) (*UploadSession, error)
+	Update(session *UploadSession) error
+	Delete(uuid string) error
+}
+
+// JSONSessionStore is the default UploadSessionStore. It keeps sessions in
+// memory, and mirrors each one to a JSON sidecar file next to its temp file
+// (named <temp file>.json) so that a Get after a restart - where the
+// in-memory map is empty - can recover the offset from disk instead of
+// failing. The rolling hash isn't part of that sidecar, so a recovered
+// session has its hasher rebuilt by re-reading the bytes already written to
+// the temp file.
+type JSONSessionStore struct {
+	// Dir is where sidecar files are looked up on a Get that misses the
+	// in-memory map. It should match the Tools.UploadSessionDir the
+	// sessions were created under.
+	Dir string
+
+	mu       sync.Mutex
+	sessions map[string]*UploadSession
+}
+
+// NewJSONSessionStore returns an empty JSONSessionStore that recovers
+// sidecar files from dir.
+func NewJSONSessionStore(dir string) *JSONSessionStore {
+	return &JSONSessionStore{Dir: dir, sessions: make(map[string]*UploadSession)}
+}
+
+func (s *JSONSessionStore) Create(session *UploadSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.UUID] = session
+	return s.writeSidecar(session)
+}
+
+func (s *JSONSessionStore) Get(uuid string) (*UploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[uuid]; ok {
+		return session, nil
+	}
+
+	session, err := s.recoverFromSidecar(uuid)
+	if err != nil {
+		return nil, fmt.Errorf("no upload session found for %s", uuid)
+	}
+
+	s.sessions[uuid] = session
+
+	return session, nil
+}
+
+// recoverFromSidecar reconstructs a session from its JSON sidecar file,
+// rebuilding the unexported rolling hash by re-reading the bytes already
+// written to the temp file, since the hasher's state isn't itself
+// serialized.
+func (s *JSONSessionStore) recoverFromSidecar(uuid string) (*UploadSession, error) {
+	tempPath := filepath.Join(s.Dir, uuid+".tmp")
+
+	data, err := os.ReadFile(tempPath + ".json")
+	if err != nil {
+		return nil, err
+	}
+
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	f, err := os.Open(session.TempPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := io.CopyN(hasher, f, session.Offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	session.hasher = hasher
+
+	return &session, nil
+}
+
+func (s *JSONSessionStore) Update(session *UploadSession) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[session.UUID] = session
+	return s.writeSidecar(session)
+}
+
+func (s *JSONSessionStore) Delete(uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[uuid]
+	if ok {
+		_ = os.Remove(s.sidecarPath(session))
+	}
+	delete(s.sessions, uuid)
+
+	return nil
+}
+
+func (s *JSONSessionStore) sidecarPath(session *UploadSession) string {
+	return session.TempPath + ".json"
+}
+
+// writeSidecar marshals the session's exported fields to its sidecar file.
+// The hasher field is unexported and so is never written; the rolling hash
+// only ever needs to survive for the life of the process that's accepting
+// the PATCH requests.
+func (s *JSONSessionStore) writeSidecar(session *UploadSession) error {
+	out, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.sidecarPath(session), out, 0644)
+}
+
+func (t *Tools) sessionStore() UploadSessionStore {
+	if t.UploadSessions == nil {
+		t.UploadSessions = NewJSONSessionStore(t.uploadSessionDir())
+	}
+	return t.UploadSessions
+}
+
+func (t *Tools) uploadSessionDir() string {
+	if t.UploadSessionDir != "" {
+		return t.UploadSessionDir
+	}
+	return os.TempDir()
+}
+
+// StartUpload begins a resumable upload and returns its UUID along with the
+// Location URL the client should PATCH against. It writes the Location and
+// Docker-Upload-UUID headers and a 202 Accepted status to w.
+func (t *Tools) StartUpload(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	if err := t.CreateDirIfNotExists(t.uploadSessionDir()); err != nil {
+		return "", "", err
+	}
+
+	uuid := t.RandomString(32)
+	tempPath := filepath.Join(t.uploadSessionDir(), uuid+".tmp")
+
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return "", "", err
+	}
+	f.Close()
+
+	session := &UploadSession{
+		UUID:      uuid,
+		StartedAt: time.Now(),
+		Offset:    0,
+		TempPath:  tempPath,
+		hasher:    sha256.New(),
+	}
+
+	if err := t.sessionStore().Create(session); err != nil {
+		return "", "", err
+	}
+
+	location := fmt.Sprintf("/uploads/%s", uuid)
+	w.Header().Set("Location", location)
+	w.Header().Set("Docker-Upload-UUID", uuid)
+	w.WriteHeader(http.StatusAccepted)
+
+	return uuid, location, nil
+}
+
+// AppendUpload appends the bytes in r's body, as described by its
+// Content-Range header, to the upload identified by uploadID. The
+// Content-Range value is "start-end" (not the "bytes=" form used by GET
+// range requests), matching the Docker blob upload protocol. A Content-Range
+// whose start does not match the session's current offset is rejected with
+// 416 Range Not Satisfiable.
+func (t *Tools) AppendUpload(w http.ResponseWriter, r *http.Request, uploadID string) (int64, error) {
+	session, err := t.sessionStore().Get(uploadID)
+	if err != nil {
+		return 0, err
+	}
+
+	start, _, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return session.Offset, err
+	}
+
+	if start != session.Offset {
+		w.Header().Set("Range", fmt.Sprintf("0-%d", session.Offset-1))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return session.Offset, fmt.Errorf("expected Content-Range to start at %d, got %d", session.Offset, start)
+	}
+
+	if t.MaxFileSize == 0 {
+		t.MaxFileSize = 1024 * 1024 * 1024
+	}
+
+	f, err := os.OpenFile(session.TempPath, os.O_RDWR, 0644)
+	if err != nil {
+		return session.Offset, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(session.Offset, io.SeekStart); err != nil {
+		return session.Offset, err
+	}
+
+	limited := io.LimitReader(r.Body, int64(t.MaxFileSize)-session.Offset+1)
+	n, err := io.Copy(f, limited)
+	if err != nil {
+		return session.Offset, err
+	}
+
+	if session.Offset+n > int64(t.MaxFileSize) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return session.Offset, errors.New("upload exceeds the maximum allowed file size")
+	}
+
+	// Drop any bytes a previous, larger (and ultimately rejected) chunk at
+	// this same offset left behind past the end of what was just written.
+	if err := f.Truncate(session.Offset + n); err != nil {
+		return session.Offset, err
+	}
+
+	// Only now, having confirmed the chunk fits, fold it into the rolling
+	// hash - doing this as part of the copy above would permanently
+	// pollute session.hasher with bytes from a rejected chunk, breaking
+	// the digest check for every retry that follows.
+	if _, err := f.Seek(session.Offset, io.SeekStart); err != nil {
+		return session.Offset, err
+	}
+	if _, err := io.CopyN(session.hasher, f, n); err != nil {
+		return session.Offset, err
+	}
+
+	session.Offset += n
+	if err := t.sessionStore().Update(session); err != nil {
+		return session.Offset, err
+	}
+
+	w.Header().Set("Range", fmt.Sprintf("0-%d", session.Offset-1))
+	w.Header().Set("Docker-Upload-UUID", session.UUID)
+	w.WriteHeader(http.StatusAccepted)
+
+	return session.Offset, nil
+}
+
+// FinishUpload closes out a resumable upload, optionally verifying the
+// caller-supplied "?digest=sha256:..." query parameter against the rolling
+// hash accumulated over the AppendUpload calls, and moves the assembled
+// temp file into destDir using the same rename logic as UploadFiles.
+func (t *Tools) FinishUpload(w http.ResponseWriter, r *http.Request, uploadID, destDir string) (*UploadedFile, error) {
+	session, err := t.sessionStore().Get(uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	if digest := r.URL.Query().Get("digest"); digest != "" {
+		want := strings.TrimPrefix(digest, "sha256:")
+		got := hex.EncodeToString(session.hasher.Sum(nil))
+		if !strings.EqualFold(want, got) {
+			return nil, fmt.Errorf("digest mismatch: expected %s, got %s", want, got)
+		}
+	}
+
+	fileType, err := sniffFileType(session.TempPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.AllowedFileTypes) > 0 {
+		allowed := false
+		for _, x := range t.AllowedFileTypes {
+			if strings.EqualFold(fileType, x) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, errors.New("the uploaded file type is not permitted")
+		}
+	}
+
+	if err := t.CreateDirIfNotExists(destDir); err != nil {
+		return nil, err
+	}
+
+	newFileName := fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(r.URL.Query().Get("filename")))
+	destPath := filepath.Join(destDir, newFileName)
+
+	if err := os.Rename(session.TempPath, destPath); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(destPath)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = t.sessionStore().Delete(uploadID)
+
+	uploadedFile := &UploadedFile{
+		NewFileName:      newFileName,
+		OriginalFileName: r.URL.Query().Get("filename"),
+		FileSize:         info.Size(),
+	}
+
+	w.WriteHeader(http.StatusCreated)
+
+	return uploadedFile, nil
+}
+
+// sniffFileType reads the first 512 bytes of the file at path and returns
+// its sniffed content type, the same way UploadFiles does for multipart
+// uploads.
+func sniffFileType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buff := make([]byte, 512)
+	n, err := io.ReadFull(f, buff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	return http.DetectContentType(buff[:n]), nil
+}
+
+// parseContentRange parses a Docker-style "start-end" Content-Range value
+// (not the "bytes=" syntax used for GET range requests).
+func parseContentRange(value string) (start, end int64, err error) {
+	parts := strings.SplitN(value, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("malformed Content-Range: %q", value)
+	}
+
+	start, err = strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed Content-Range: %q", value)
+	}
+
+	end, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed Content-Range: %q", value)
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,246 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTools_ResumableUpload(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	sessionDir := "./testdata/sessions"
+	defer os.RemoveAll(sessionDir)
+
+	var testTools Tools
+	testTools.UploadSessionDir = sessionDir
+
+	startReq := httptest.NewRequest("POST", "/uploads", nil)
+	startRR := httptest.NewRecorder()
+	uploadID, location, err := testTools.StartUpload(startRR, startReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location == "" {
+		t.Error("expected a non-empty Location")
+	}
+	if startRR.Code != 202 {
+		t.Errorf("expected 202 Accepted, got %d", startRR.Code)
+	}
+
+	chunks := []string{"hello, ", "resumable ", "world"}
+	var full strings.Builder
+	offset := int64(0)
+	for _, chunk := range chunks {
+		full.WriteString(chunk)
+
+		patchReq := httptest.NewRequest("PATCH", location, strings.NewReader(chunk))
+		patchReq.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1))
+		patchRR := httptest.NewRecorder()
+
+		newOffset, err := testTools.AppendUpload(patchRR, patchReq, uploadID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if patchRR.Code != 202 {
+			t.Errorf("expected 202 Accepted, got %d", patchRR.Code)
+		}
+
+		offset = newOffset
+	}
+
+	sum := sha256.Sum256([]byte(full.String()))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	putReq := httptest.NewRequest("PUT", location+"?digest="+digest, nil)
+	putRR := httptest.NewRecorder()
+
+	uploaded, err := testTools.FinishUpload(putRR, putReq, uploadID, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, uploaded.NewFileName))
+
+	if putRR.Code != 201 {
+		t.Errorf("expected 201 Created, got %d", putRR.Code)
+	}
+
+	contents, err := os.ReadFile(fmt.Sprintf("%s/%s", uploadDir, uploaded.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != full.String() {
+		t.Errorf("assembled file contents do not match: got %q", contents)
+	}
+}
+
+func TestTools_FinishUpload_RejectsDisallowedFileType(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	sessionDir := "./testdata/sessions-disallowed-type"
+	defer os.RemoveAll(sessionDir)
+
+	var testTools Tools
+	testTools.UploadSessionDir = sessionDir
+	testTools.AllowedFileTypes = []string{"image/png"}
+
+	startReq := httptest.NewRequest("POST", "/uploads", nil)
+	uploadID, location, err := testTools.StartUpload(httptest.NewRecorder(), startReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script := "#!/bin/sh\necho hi\n"
+	patchReq := httptest.NewRequest("PATCH", location, strings.NewReader(script))
+	patchReq.Header.Set("Content-Range", fmt.Sprintf("0-%d", len(script)-1))
+	if _, err := testTools.AppendUpload(httptest.NewRecorder(), patchReq, uploadID); err != nil {
+		t.Fatal(err)
+	}
+
+	putReq := httptest.NewRequest("PUT", location+"?filename=payload.sh", nil)
+	putRR := httptest.NewRecorder()
+
+	uploaded, err := testTools.FinishUpload(putRR, putReq, uploadID, uploadDir)
+	if err == nil {
+		defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, uploaded.NewFileName))
+		t.Fatal("expected FinishUpload to reject a file type outside AllowedFileTypes")
+	}
+}
+
+func TestTools_ResumableUpload_SurvivesRestart(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	sessionDir := "./testdata/sessions-restart"
+	defer os.RemoveAll(sessionDir)
+
+	var before Tools
+	before.UploadSessionDir = sessionDir
+
+	startReq := httptest.NewRequest("POST", "/uploads", nil)
+	uploadID, location, err := before.StartUpload(httptest.NewRecorder(), startReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := "first half, "
+	patchReq := httptest.NewRequest("PATCH", location, strings.NewReader(chunk))
+	patchReq.Header.Set("Content-Range", fmt.Sprintf("0-%d", len(chunk)-1))
+	offset, err := before.AppendUpload(httptest.NewRecorder(), patchReq, uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh Tools/JSONSessionStore, as if the process had restarted, but
+	// pointed at the same UploadSessionDir.
+	var after Tools
+	after.UploadSessionDir = sessionDir
+
+	rest := "second half"
+	patchReq2 := httptest.NewRequest("PATCH", location, strings.NewReader(rest))
+	patchReq2.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(rest))-1))
+	if _, err := after.AppendUpload(httptest.NewRecorder(), patchReq2, uploadID); err != nil {
+		t.Fatalf("expected the session to be recovered from its sidecar after a restart: %v", err)
+	}
+
+	putReq := httptest.NewRequest("PUT", location, nil)
+	putRR := httptest.NewRecorder()
+	uploaded, err := after.FinishUpload(putRR, putReq, uploadID, uploadDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, uploaded.NewFileName))
+
+	contents, err := os.ReadFile(fmt.Sprintf("%s/%s", uploadDir, uploaded.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != chunk+rest {
+		t.Errorf("assembled file contents do not match: got %q", contents)
+	}
+}
+
+func TestTools_AppendUpload_OversizedChunkDoesNotPolluteDigest(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	sessionDir := "./testdata/sessions-oversized-chunk"
+	defer os.RemoveAll(sessionDir)
+
+	var testTools Tools
+	testTools.UploadSessionDir = sessionDir
+	testTools.MaxFileSize = 10
+
+	startReq := httptest.NewRequest("POST", "/uploads", nil)
+	uploadID, location, err := testTools.StartUpload(httptest.NewRecorder(), startReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tooBig := "this chunk is way over the ten byte limit"
+	patchReq := httptest.NewRequest("PATCH", location, strings.NewReader(tooBig))
+	patchReq.Header.Set("Content-Range", fmt.Sprintf("0-%d", len(tooBig)-1))
+	patchRR := httptest.NewRecorder()
+
+	if _, err := testTools.AppendUpload(patchRR, patchReq, uploadID); err == nil {
+		t.Fatal("expected an error for a chunk exceeding MaxFileSize")
+	}
+	if patchRR.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 Request Entity Too Large, got %d", patchRR.Code)
+	}
+
+	// Retrying with a chunk that actually fits must succeed, and the
+	// resulting digest must match only the accepted bytes - not be
+	// poisoned by the rejected chunk above.
+	good := "0123456789"
+	patchReq2 := httptest.NewRequest("PATCH", location, strings.NewReader(good))
+	patchReq2.Header.Set("Content-Range", fmt.Sprintf("0-%d", len(good)-1))
+	if _, err := testTools.AppendUpload(httptest.NewRecorder(), patchReq2, uploadID); err != nil {
+		t.Fatalf("expected the retry with a correctly-sized chunk to succeed: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte(good))
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	putReq := httptest.NewRequest("PUT", location+"?digest="+digest, nil)
+	putRR := httptest.NewRecorder()
+
+	uploaded, err := testTools.FinishUpload(putRR, putReq, uploadID, uploadDir)
+	if err != nil {
+		t.Fatalf("expected digest to match the accepted bytes after the rejected retry: %v", err)
+	}
+	defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, uploaded.NewFileName))
+
+	contents, err := os.ReadFile(fmt.Sprintf("%s/%s", uploadDir, uploaded.NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != good {
+		t.Errorf("expected assembled file to contain only the accepted chunk %q, got %q", good, contents)
+	}
+}
+
+func TestTools_AppendUpload_WrongOffset(t *testing.T) {
+	sessionDir := "./testdata/sessions-wrong-offset"
+	defer os.RemoveAll(sessionDir)
+
+	var testTools Tools
+	testTools.UploadSessionDir = sessionDir
+
+	startReq := httptest.NewRequest("POST", "/uploads", nil)
+	uploadID, _, err := testTools.StartUpload(httptest.NewRecorder(), startReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patchReq := httptest.NewRequest("PATCH", "/uploads/"+uploadID, strings.NewReader("oops"))
+	patchReq.Header.Set("Content-Range", "10-13")
+	patchRR := httptest.NewRecorder()
+
+	_, err = testTools.AppendUpload(patchRR, patchReq, uploadID)
+	if err == nil {
+		t.Error("expected an error for a Content-Range that skips ahead of the current offset")
+	}
+	if patchRR.Code != 416 {
+		t.Errorf("expected 416 Range Not Satisfiable, got %d", patchRR.Code)
+	}
+}
@@ -0,0 +1,156 @@
+package toolkit
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTools_ServeStaticFile_SingleRange(t *testing.T) {
+	data, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=0-9")
+
+	if err := testTools.ServeStaticFile(rr, req, "./testdata", "img.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := rr.Result()
+	if res.StatusCode != http.StatusPartialContent {
+		t.Errorf("expected 206, got %d", res.StatusCode)
+	}
+	if got := res.Header.Get("Content-Range"); got != "bytes 0-9/11881" {
+		t.Errorf("wrong Content-Range: %s", got)
+	}
+	if got := res.Header.Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("wrong Accept-Ranges: %s", got)
+	}
+	if res.Header.Get("ETag") == "" {
+		t.Error("expected an ETag header")
+	}
+
+	body := make([]byte, 10)
+	if _, err := res.Body.Read(body); err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != string(data[:10]) {
+		t.Error("wrong bytes returned for range")
+	}
+}
+
+func TestTools_ServeStaticFile_SuffixRange(t *testing.T) {
+	data, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=-5")
+
+	if err := testTools.ServeStaticFile(rr, req, "./testdata", "img.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := rr.Result()
+	size := len(data)
+	want := "bytes " + strconv.Itoa(size-5) + "-" + strconv.Itoa(size-1) + "/" + strconv.Itoa(size)
+	if got := res.Header.Get("Content-Range"); got != want {
+		t.Errorf("wrong Content-Range: got %s, want %s", got, want)
+	}
+}
+
+func TestTools_ServeStaticFile_OpenEndedRange(t *testing.T) {
+	data, err := os.ReadFile("./testdata/img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=2-")
+
+	if err := testTools.ServeStaticFile(rr, req, "./testdata", "img.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := rr.Result()
+	size := len(data)
+	want := "bytes 2-" + strconv.Itoa(size-1) + "/" + strconv.Itoa(size)
+	if got := res.Header.Get("Content-Range"); got != want {
+		t.Errorf("wrong Content-Range: got %s, want %s", got, want)
+	}
+}
+
+func TestTools_ServeStaticFile_MultiRange(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=0-1,5-8")
+
+	if err := testTools.ServeStaticFile(rr, req, "./testdata", "img.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := rr.Result()
+	if res.StatusCode != http.StatusPartialContent {
+		t.Errorf("expected 206, got %d", res.StatusCode)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/byteranges" {
+		t.Errorf("expected multipart/byteranges, got %s", mediaType)
+	}
+}
+
+func TestTools_ServeStaticFile_UnsatisfiableRange(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Range", "bytes=999999999-")
+
+	if err := testTools.ServeStaticFile(rr, req, "./testdata", "img.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := rr.Result()
+	if res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("expected 416, got %d", res.StatusCode)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Range"), "bytes */") {
+		t.Errorf("wrong Content-Range for unsatisfiable request: %s", res.Header.Get("Content-Range"))
+	}
+}
+
+func TestTools_ServeStaticFile_HEAD(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("HEAD", "/", nil)
+
+	if err := testTools.ServeStaticFile(rr, req, "./testdata", "img.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	res := rr.Result()
+	if res.Header.Get("Content-Length") != "11881" {
+		t.Errorf("wrong Content-Length on HEAD: %s", res.Header.Get("Content-Length"))
+	}
+	if rr.Body.Len() != 0 {
+		t.Error("expected no body for a HEAD request")
+	}
+}
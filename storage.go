@@ -0,0 +1,207 @@
+package toolkit
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// FileStat describes a single stored object, as returned by a Storage's
+// Stat method.
+type FileStat struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is implemented by anything that can durably store the bytes
+// behind an uploaded file and hand back a URL at which it can later be
+// retrieved. Tools.UploadFiles and Tools.UploadOneFile write through a
+// Storage instead of talking to the filesystem directly, so the same
+// upload code works whether files end up on local disk, in S3, or
+// somewhere else entirely.
+type Storage interface {
+	// Put stores the contents of r under name, returning a URL that can be
+	// used to fetch it back.
+	Put(name string, r io.Reader, contentType string) (url string, err error)
+
+	// Get returns a reader for the object previously stored under name.
+	// Callers are responsible for closing the returned reader.
+	Get(name string) (io.ReadCloser, error)
+
+	// Delete removes the object stored under name.
+	Delete(name string) error
+
+	// Stat returns metadata about the object stored under name.
+	Stat(name string) (FileStat, error)
+}
+
+// LocalFSStorage is a Storage that writes to a directory on the local
+// filesystem. This is the toolkit's original upload behavior, lifted
+// behind the Storage interface so it can be swapped for something else.
+type LocalFSStorage struct {
+	// RootDir is the directory under which files are stored. It is
+	// created, along with any missing parents, on first use.
+	RootDir string
+
+	// BaseURL, when set, is prepended to a file's name to build the URL
+	// returned from Put. If empty, Put returns just the file name.
+	BaseURL string
+}
+
+// NewLocalFSStorage returns a LocalFSStorage rooted at rootDir.
+func NewLocalFSStorage(rootDir, baseURL string) *LocalFSStorage {
+	return &LocalFSStorage{RootDir: rootDir, BaseURL: baseURL}
+}
+
+func (l *LocalFSStorage) Put(name string, r io.Reader, contentType string) (string, error) {
+	var t Tools
+	if err := t.CreateDirIfNotExists(l.RootDir); err != nil {
+		return "", err
+	}
+
+	outfile, err := os.Create(filepath.Join(l.RootDir, name))
+	if err != nil {
+		return "", err
+	}
+	defer outfile.Close()
+
+	if _, err := io.Copy(outfile, r); err != nil {
+		return "", err
+	}
+
+	return l.url(name), nil
+}
+
+func (l *LocalFSStorage) Get(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.RootDir, name))
+}
+
+func (l *LocalFSStorage) Delete(name string) error {
+	return os.Remove(filepath.Join(l.RootDir, name))
+}
+
+func (l *LocalFSStorage) Stat(name string) (FileStat, error) {
+	info, err := os.Stat(filepath.Join(l.RootDir, name))
+	if err != nil {
+		return FileStat{}, err
+	}
+
+	return FileStat{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *LocalFSStorage) url(name string) string {
+	if l.BaseURL == "" {
+		return name
+	}
+	return strings.TrimSuffix(l.BaseURL, "/") + "/" + name
+}
+
+// S3Storage is a Storage that stores objects in an S3-compatible bucket. In
+// addition to AWS S3 itself, setting Endpoint lets it talk to Minio,
+// Cloudflare R2, or any other service that speaks the S3 API.
+type S3Storage struct {
+	Bucket   string
+	Region   string
+	Endpoint string
+
+	// BaseURL, when set, is prepended to a file's name to build the URL
+	// returned from Put. If empty, the bucket's virtual-hosted-style URL
+	// is used.
+	BaseURL string
+
+	client *s3.Client
+}
+
+// NewS3Storage returns an S3Storage for the given bucket and region.
+// Endpoint may be left empty to use AWS's default S3 endpoint, or set to
+// point at a Minio/R2-compatible service.
+func NewS3Storage(bucket, region, endpoint string) (*S3Storage, error) {
+	ctx := context.Background()
+
+	optFns := []func(*config.LoadOptions) error{
+		config.WithRegion(region),
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &S3Storage{Bucket: bucket, Region: region, Endpoint: endpoint, client: client}, nil
+}
+
+func (s *S3Storage) Put(name string, r io.Reader, contentType string) (string, error) {
+	_, err := s.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(name),
+		Body:        r,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return s.url(name), nil
+}
+
+func (s *S3Storage) Get(name string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(name),
+	})
+	return err
+}
+
+func (s *S3Storage) Stat(name string) (FileStat, error) {
+	out, err := s.client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return FileStat{}, err
+	}
+
+	stat := FileStat{Name: name, Size: aws.ToInt64(out.ContentLength)}
+	if out.LastModified != nil {
+		stat.ModTime = *out.LastModified
+	}
+
+	return stat, nil
+}
+
+func (s *S3Storage) url(name string) string {
+	if s.BaseURL != "" {
+		return strings.TrimSuffix(s.BaseURL, "/") + "/" + name
+	}
+	if s.Endpoint != "" {
+		return strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + name
+	}
+	return "https://" + s.Bucket + ".s3." + s.Region + ".amazonaws.com/" + name
+}
@@ -0,0 +1,66 @@
+package toolkit
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestLocalFSStorage_PutGetDeleteStat(t *testing.T) {
+	storage := NewLocalFSStorage("./testdata/storage", "http://example.com/files")
+	defer os.RemoveAll("./testdata/storage")
+
+	url, err := storage.Put("hello.txt", bytes.NewBufferString("hello world"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if url != "http://example.com/files/hello.txt" {
+		t.Errorf("wrong url returned: %s", url)
+	}
+
+	stat, err := storage.Stat("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Size != 11 {
+		t.Errorf("wrong size reported: %d", stat.Size)
+	}
+
+	rc, err := storage.Get("hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("wrong contents read back: %s", got)
+	}
+
+	if err := storage.Delete("hello.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.Stat("hello.txt"); !os.IsNotExist(err) {
+		t.Error("expected file to be deleted")
+	}
+}
+
+func TestLocalFSStorage_NoBaseURL(t *testing.T) {
+	storage := NewLocalFSStorage("./testdata/storage", "")
+	defer os.RemoveAll("./testdata/storage")
+
+	url, err := storage.Put("plain.txt", bytes.NewBufferString("x"), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if url != "plain.txt" {
+		t.Errorf("expected bare file name when BaseURL is empty, got %s", url)
+	}
+}
@@ -0,0 +1,410 @@
+// Package toolkit provides a small set of reusable helpers (uploads, JSON
+// request/response handling, file downloads, slugs, random strings) for
+// building HTTP services without pulling in a larger framework.
+package toolkit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_+"
+
+// Tools is the type used to instantiate this module. Any variable of this type
+// will have access to all the methods with the receiver *Tools.
+type Tools struct {
+	MaxFileSize        int
+	AllowedFileTypes   []string
+	MaxJSONSize        int
+	AllowUnknownFields bool
+
+	// Storage is where UploadFiles and UploadOneFile write the bytes of
+	// an upload. If nil, a LocalFSStorage rooted at the uploadDir passed
+	// to those methods is used, preserving the historical local-disk
+	// behavior.
+	Storage Storage
+
+	// UploadSessions tracks in-progress resumable uploads started with
+	// StartUpload. If nil, a JSONSessionStore is created on first use.
+	UploadSessions UploadSessionStore
+
+	// UploadSessionDir is where StartUpload stages the temp files for
+	// resumable uploads before FinishUpload moves them to their final
+	// destination. Defaults to os.TempDir() if empty.
+	UploadSessionDir string
+
+	// MetadataStore persists the UploadMetadata recorded by
+	// UploadFilesWithExpiry. If nil, a JSONMetadataStore rooted at
+	// MetadataDir is created on first use.
+	MetadataStore MetadataStore
+
+	// MetadataDir is where the default MetadataStore keeps its sidecar
+	// files. UploadFilesWithExpiry defaults it to the upload directory it
+	// was called with if it's still empty.
+	MetadataDir string
+
+	// ImagePipeline, when non-empty, is run against any uploaded file
+	// whose sniffed content type starts with "image/", after it has
+	// already been written to Storage.
+	ImagePipeline []ImageOp
+
+	// MaxImagePixels caps the pixel area (width * height) of images
+	// accepted by ImagePipeline, to guard against decompression-bomb
+	// uploads. Defaults to 10,000,000 if left at 0.
+	MaxImagePixels int
+
+	// ImageOpTimeout caps how long a single ImageOp is allowed to run.
+	// 0 means no timeout.
+	ImageOpTimeout time.Duration
+
+	// ExtractArchives, when true, makes UploadFiles treat an uploaded
+	// .zip, .tar or .tar.gz as a bundle to expand in place, via
+	// ExtractArchive, rather than storing it as a single opaque file.
+	// Only applies when writing to a LocalFSStorage.
+	ExtractArchives bool
+
+	// ArchiveExtractOptions is passed through to ExtractArchive when
+	// ExtractArchives is set.
+	ArchiveExtractOptions ExtractOptions
+}
+
+// UploadedFile is a struct used to save information about an uploaded file.
+type UploadedFile struct {
+	NewFileName      string
+	OriginalFileName string
+	FileSize         int64
+
+	// URL is the location at which the uploaded file can be retrieved, as
+	// returned by the Storage backend it was written to.
+	URL string
+
+	// Metadata is populated when the file was uploaded via
+	// UploadFilesWithExpiry; it is nil otherwise.
+	Metadata *UploadMetadata
+
+	// Derivatives lists any thumbnails a configured Tools.ImagePipeline
+	// wrote alongside this file.
+	Derivatives []UploadedFile
+}
+
+// RandomString returns a string of random characters of length n, using
+// randomStringSource as the source for the string.
+func (t *Tools) RandomString(n int) string {
+	s, r := make([]rune, n), []rune(randomStringSource)
+
+	for i := range s {
+		p, _ := rand.Prime(rand.Reader, len(r))
+		x, y := p.Uint64(), uint64(len(r))
+		s[i] = r[x%y]
+	}
+
+	return string(s)
+}
+
+// UploadOneFile is a convenience method that calls UploadFiles, but expects
+// only one file to be in the upload.
+func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	files, err := t.UploadFiles(r, uploadDir, renameFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return files[0], nil
+}
+
+// UploadFiles uploads one or more files to a specified directory, and gives
+// the files a random name. It returns a slice containing the newly named
+// files, the original file names, the size of the files, and potentially an
+// error. If the optional last parameter is set to false, the files are not
+// renamed, but the original file name is used instead.
+func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	var uploadedFiles []*UploadedFile
+
+	if t.MaxFileSize == 0 {
+		t.MaxFileSize = 1024 * 1024 * 1024
+	}
+
+	storage := t.Storage
+	if storage == nil {
+		storage = NewLocalFSStorage(uploadDir, "")
+		t.Storage = storage
+	}
+
+	err := r.ParseMultipartForm(int64(t.MaxFileSize))
+	if err != nil {
+		return nil, errors.New("the uploaded file is too big")
+	}
+
+	for _, fHeaders := range r.MultipartForm.File {
+		for _, hdr := range fHeaders {
+			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
+				var uploadedFile UploadedFile
+				infile, err := hdr.Open()
+				if err != nil {
+					return nil, err
+				}
+				defer infile.Close()
+
+				buff := make([]byte, 512)
+				_, err = infile.Read(buff)
+				if err != nil {
+					return nil, err
+				}
+
+				// check to see if the file type is permitted
+				allowed := false
+				fileType := http.DetectContentType(buff)
+
+				if len(t.AllowedFileTypes) > 0 {
+					for _, x := range t.AllowedFileTypes {
+						if strings.EqualFold(fileType, x) {
+							allowed = true
+						}
+					}
+				} else {
+					allowed = true
+				}
+
+				if !allowed {
+					return nil, errors.New("the uploaded file type is not permitted")
+				}
+
+				_, err = infile.Seek(0, 0)
+				if err != nil {
+					return nil, err
+				}
+
+				if renameFile {
+					uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(hdr.Filename))
+				} else {
+					uploadedFile.NewFileName = hdr.Filename
+				}
+				uploadedFile.OriginalFileName = hdr.Filename
+
+				countingReader := &countingReader{r: infile}
+				url, err := storage.Put(uploadedFile.NewFileName, countingReader, fileType)
+				if err != nil {
+					return nil, err
+				}
+				uploadedFile.URL = url
+				uploadedFile.FileSize = countingReader.n
+
+				if strings.HasPrefix(fileType, "image/") {
+					if err := t.runImagePipeline(storage, infile, &uploadedFile); err != nil {
+						_ = storage.Delete(uploadedFile.NewFileName)
+						return nil, err
+					}
+				}
+
+				if t.ExtractArchives && isArchiveName(uploadedFile.NewFileName) {
+					if err := t.runArchiveExtraction(storage, uploadDir, &uploadedFile); err != nil {
+						_ = storage.Delete(uploadedFile.NewFileName)
+						return nil, err
+					}
+				}
+
+				uploadedFiles = append(uploadedFiles, &uploadedFile)
+
+				return uploadedFiles, nil
+			}(uploadedFiles)
+			if err != nil {
+				return uploadedFiles, err
+			}
+		}
+	}
+
+	return uploadedFiles, nil
+}
+
+// countingReader wraps an io.Reader, tallying the number of bytes read
+// through it so UploadFiles can report FileSize without requiring every
+// Storage implementation to do its own accounting.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// CreateDirIfNotExists creates a directory, and all necessary parents, if it
+// does not already exist.
+func (t *Tools) CreateDirIfNotExists(path string) error {
+	const mode = 0755
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		err := os.MkdirAll(path, mode)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var slugRe = regexp.MustCompile(`[^a-z\d]+`)
+
+// Slugify is a (very) simple means of creating a slug from a string.
+func (t *Tools) Slugify(s string) (string, error) {
+	if s == "" {
+		return "", errors.New("empty string not permitted")
+	}
+
+	slug := strings.Trim(slugRe.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(slug) == 0 {
+		return "", errors.New("after removing characters, slug is zero length")
+	}
+
+	return slug, nil
+}
+
+// JSONResponse is the type used for sending JSON around.
+type JSONResponse struct {
+	Error   bool        `json:"error"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// ReadJSON tries to read the body of a request and converts it into JSON.
+func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	maxBytes := 1024 * 1024 // one megabyte
+
+	if t.MaxJSONSize != 0 {
+		maxBytes = t.MaxJSONSize
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	dec := json.NewDecoder(r.Body)
+	if !t.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	err := dec.Decode(data)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var invalidUnmarshalError *json.InvalidUnmarshalError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON at character %d", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			if unmarshalTypeError.Field != "" {
+				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+			}
+			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("body contains unknown key %s", fieldName)
+		case err.Error() == "http: request body too large":
+			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
+		case errors.As(err, &invalidUnmarshalError):
+			return fmt.Errorf("error unmarshalling JSON: %s", err.Error())
+		default:
+			return err
+		}
+	}
+
+	err = dec.Decode(&struct{}{})
+	if err != io.EOF {
+		return errors.New("body must only contain a single JSON value")
+	}
+
+	return nil
+}
+
+// WriteJSON takes a response status code and arbitrary data and writes a
+// JSON response to the client. Optional http.Header can be passed to be
+// added to the response.
+func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, headers ...http.Header) error {
+	out, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(out)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ErrorJSON takes an error, and optionally a status code, and generates and
+// sends a JSON error response.
+func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
+	statusCode := http.StatusBadRequest
+
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
+	var payload JSONResponse
+	payload.Error = true
+	payload.Message = err.Error()
+
+	return t.WriteJSON(w, statusCode, payload)
+}
+
+// PushJSONToRemote posts arbitrary data to some URL as JSON, and returns the
+// response, status code, and error, if any. The final parameter, client, is
+// optional. If none is specified, we use the standard http.Client.
+func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	httpClient := &http.Client{}
+	if len(client) > 0 {
+		httpClient = client[0]
+	}
+
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	return response, response.StatusCode, nil
+}
@@ -0,0 +1,296 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// neverExpire is the sentinel Expiry used for uploads that should never be
+// reaped by StartExpiryReaper. A plain zero time.Time can't be used for
+// this, since it's also what an UploadMetadata has before Expiry is set -
+// the ambiguity this avoids is the same one linx-server's display handler
+// had to work around.
+var neverExpire = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// UploadMetadata describes an uploaded file: when it expires, the key
+// required to delete it early, and a few details about the original
+// upload.
+type UploadMetadata struct {
+	Expiry       time.Time
+	DeleteKey    string
+	SHA256       string
+	OriginalName string
+	ContentType  string
+	UploadedAt   time.Time
+}
+
+// MetadataStore persists an UploadMetadata alongside an uploaded file, and
+// lets the expiry reaper enumerate everything it knows about.
+type MetadataStore interface {
+	Save(name string, meta *UploadMetadata) error
+	Load(name string) (*UploadMetadata, error)
+	Delete(name string) error
+	List() ([]string, error)
+}
+
+// JSONMetadataStore is the default MetadataStore. It keeps a "<name>.json"
+// sidecar file next to each upload, inside Dir.
+type JSONMetadataStore struct {
+	Dir string
+}
+
+// NewJSONMetadataStore returns a JSONMetadataStore that keeps its sidecar
+// files in dir.
+func NewJSONMetadataStore(dir string) *JSONMetadataStore {
+	return &JSONMetadataStore{Dir: dir}
+}
+
+func (s *JSONMetadataStore) sidecarPath(name string) string {
+	return filepath.Join(s.Dir, name+".json")
+}
+
+func (s *JSONMetadataStore) Save(name string, meta *UploadMetadata) error {
+	var t Tools
+	if err := t.CreateDirIfNotExists(s.Dir); err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(s.sidecarPath(name), out, 0644)
+}
+
+func (s *JSONMetadataStore) Load(name string) (*UploadMetadata, error) {
+	data, err := os.ReadFile(s.sidecarPath(name))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta UploadMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+func (s *JSONMetadataStore) Delete(name string) error {
+	return os.Remove(s.sidecarPath(name))
+}
+
+func (s *JSONMetadataStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+
+	return names, nil
+}
+
+func (t *Tools) metadataStore() MetadataStore {
+	if t.MetadataStore == nil {
+		dir := t.MetadataDir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		t.MetadataStore = NewJSONMetadataStore(dir)
+	}
+	return t.MetadataStore
+}
+
+// UploadFilesWithExpiry behaves like UploadFiles, but also records
+// UploadMetadata (a delete key, a SHA256 of the uploaded bytes and an
+// expiry) for each file, and populates UploadedFile.Metadata with it.
+// expiry of 0 means the file never expires. If Tools.MetadataDir is unset,
+// it defaults to uploadDir, so the metadata sidecar lands next to the blob.
+func (t *Tools) UploadFilesWithExpiry(r *http.Request, uploadDir string, expiry time.Duration, rename ...bool) ([]*UploadedFile, error) {
+	if t.MetadataDir == "" {
+		t.MetadataDir = uploadDir
+	}
+
+	files, err := t.UploadFiles(r, uploadDir, rename...)
+	if err != nil {
+		return files, err
+	}
+
+	exp := neverExpire
+	if expiry > 0 {
+		exp = time.Now().Add(expiry)
+	}
+
+	// UploadFiles has already resolved and cached t.Storage by this point.
+	store := t.metadataStore()
+
+	for _, file := range files {
+		sum, contentType, err := sniffAndHash(t.Storage, file.NewFileName)
+		if err != nil {
+			return files, err
+		}
+
+		meta := &UploadMetadata{
+			Expiry:       exp,
+			DeleteKey:    t.RandomString(32),
+			SHA256:       sum,
+			OriginalName: file.OriginalFileName,
+			ContentType:  contentType,
+			UploadedAt:   time.Now(),
+		}
+
+		if err := store.Save(file.NewFileName, meta); err != nil {
+			return files, err
+		}
+
+		file.Metadata = meta
+	}
+
+	return files, nil
+}
+
+func sniffAndHash(storage Storage, name string) (sum, contentType string, err error) {
+	rc, err := storage.Get(name)
+	if err != nil {
+		return "", "", err
+	}
+	defer rc.Close()
+
+	buff := make([]byte, 512)
+	n, _ := io.ReadFull(rc, buff)
+	contentType = http.DetectContentType(buff[:n])
+
+	hasher := sha256.New()
+	hasher.Write(buff[:n])
+	if _, err := io.Copy(hasher, rc); err != nil {
+		return "", "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), contentType, nil
+}
+
+// DeleteUpload removes the uploaded file called name, along with its
+// metadata sidecar, after confirming key matches the delete key recorded in
+// its UploadMetadata. The comparison is constant-time so a delete key can't
+// be brute-forced a character at a time via timing.
+func (t *Tools) DeleteUpload(name, key string) error {
+	store := t.metadataStore()
+
+	meta, err := store.Load(name)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(meta.DeleteKey), []byte(key)) != 1 {
+		return fmt.Errorf("invalid delete key for %s", name)
+	}
+
+	return t.deleteUploadAndMetadata(name)
+}
+
+// deleteUploadAndMetadata removes name from t.Storage, which UploadFiles
+// resolves and caches on first use - so it always points at the directory
+// the file was actually uploaded to, even when that differs from
+// t.MetadataDir.
+func (t *Tools) deleteUploadAndMetadata(name string) error {
+	if t.Storage == nil {
+		return fmt.Errorf("no Storage configured for %s; was it ever uploaded through this Tools?", name)
+	}
+
+	if err := t.Storage.Delete(name); err != nil {
+		return err
+	}
+
+	return t.metadataStore().Delete(name)
+}
+
+// StartExpiryReaper periodically scans the configured MetadataStore and
+// deletes any upload whose Expiry has passed. It runs until ctx is
+// cancelled.
+func (t *Tools) StartExpiryReaper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				t.reapExpiredUploads()
+			}
+		}
+	}()
+}
+
+func (t *Tools) reapExpiredUploads() {
+	store := t.metadataStore()
+
+	names, err := store.List()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, name := range names {
+		meta, err := store.Load(name)
+		if err != nil {
+			continue
+		}
+
+		if meta.Expiry.IsZero() || meta.Expiry.Equal(neverExpire) || meta.Expiry.After(now) {
+			continue
+		}
+
+		_ = t.deleteUploadAndMetadata(name)
+	}
+}
+
+// uploadAPIResponse is the shape written by WriteUploadResponse.
+type uploadAPIResponse struct {
+	Filename  string `json:"filename"`
+	URL       string `json:"url"`
+	DeleteKey string `json:"delete_key,omitempty"`
+	Expiry    string `json:"expiry,omitempty"`
+	Size      int64  `json:"size"`
+}
+
+// WriteUploadResponse writes the standard JSON response for an upload API:
+// {filename, url, delete_key, expiry, size}. delete_key and expiry are
+// omitted if file has no Metadata.
+func (t *Tools) WriteUploadResponse(w http.ResponseWriter, status int, file *UploadedFile) error {
+	resp := uploadAPIResponse{
+		Filename: file.NewFileName,
+		URL:      file.URL,
+		Size:     file.FileSize,
+	}
+
+	if file.Metadata != nil {
+		resp.DeleteKey = file.Metadata.DeleteKey
+		if file.Metadata.Expiry.Equal(neverExpire) {
+			resp.Expiry = "never"
+		} else {
+			resp.Expiry = file.Metadata.Expiry.Format(time.RFC3339)
+		}
+	}
+
+	return t.WriteJSON(w, status, resp)
+}
@@ -0,0 +1,166 @@
+package toolkit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func uploadTestFile(t *testing.T, tools *Tools, uploadDir string, expiry time.Duration) *UploadedFile {
+	t.Helper()
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer writer.Close()
+		defer wg.Done()
+
+		part, err := writer.CreateFormFile("file", "test.txt")
+		if err != nil {
+			t.Error(err)
+		}
+		_, _ = part.Write([]byte("some file contents"))
+	}()
+
+	request := httptest.NewRequest("POST", "/", pr)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	files, err := tools.UploadFilesWithExpiry(request, uploadDir, expiry, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	return files[0]
+}
+
+func TestTools_UploadFilesWithExpiry_NeverExpires(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	var testTools Tools
+
+	file := uploadTestFile(t, &testTools, uploadDir, 0)
+	defer os.Remove(fmt.Sprintf("%s/%s", uploadDir, file.NewFileName))
+	defer os.Remove(fmt.Sprintf("%s/%s.json", uploadDir, file.NewFileName))
+
+	if file.Metadata == nil {
+		t.Fatal("expected metadata to be populated")
+	}
+	if !file.Metadata.Expiry.Equal(neverExpire) {
+		t.Errorf("expected never-expire sentinel, got %v", file.Metadata.Expiry)
+	}
+	if file.Metadata.DeleteKey == "" {
+		t.Error("expected a delete key to be generated")
+	}
+	if file.Metadata.SHA256 == "" {
+		t.Error("expected a sha256 to be recorded")
+	}
+}
+
+func TestTools_DeleteUpload(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	var testTools Tools
+
+	file := uploadTestFile(t, &testTools, uploadDir, time.Hour)
+	blobPath := fmt.Sprintf("%s/%s", uploadDir, file.NewFileName)
+	sidecarPath := blobPath + ".json"
+
+	if err := testTools.DeleteUpload(file.NewFileName, "wrong-key"); err == nil {
+		t.Error("expected an error when deleting with the wrong key")
+	}
+
+	if err := testTools.DeleteUpload(file.NewFileName, file.Metadata.DeleteKey); err != nil {
+		t.Fatalf("unexpected error deleting with the correct key: %v", err)
+	}
+
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Error("expected blob to be removed")
+		os.Remove(blobPath)
+	}
+	if _, err := os.Stat(sidecarPath); !os.IsNotExist(err) {
+		t.Error("expected metadata sidecar to be removed")
+		os.Remove(sidecarPath)
+	}
+}
+
+func TestTools_DeleteUpload_SeparateMetadataDir(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	metadataDir := "./testdata/metadata"
+	defer os.RemoveAll(metadataDir)
+
+	var testTools Tools
+	testTools.MetadataDir = metadataDir
+
+	file := uploadTestFile(t, &testTools, uploadDir, time.Hour)
+	blobPath := fmt.Sprintf("%s/%s", uploadDir, file.NewFileName)
+
+	if err := testTools.DeleteUpload(file.NewFileName, file.Metadata.DeleteKey); err != nil {
+		t.Fatalf("unexpected error deleting with the correct key: %v", err)
+	}
+
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		t.Error("expected blob to be removed even though MetadataDir differs from the upload dir")
+		os.Remove(blobPath)
+	}
+}
+
+func TestTools_StartExpiryReaper(t *testing.T) {
+	uploadDir := "./testdata/uploads"
+	var testTools Tools
+
+	file := uploadTestFile(t, &testTools, uploadDir, 10*time.Millisecond)
+	blobPath := fmt.Sprintf("%s/%s", uploadDir, file.NewFileName)
+	defer os.Remove(blobPath)
+	defer os.Remove(blobPath + ".json")
+
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	testTools.StartExpiryReaper(ctx, 10*time.Millisecond)
+	defer cancel()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(blobPath); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Error("expected expired upload to be reaped")
+}
+
+func TestTools_WriteUploadResponse(t *testing.T) {
+	var testTools Tools
+	rr := httptest.NewRecorder()
+
+	file := &UploadedFile{
+		NewFileName: "foo.txt",
+		URL:         "http://example.com/foo.txt",
+		FileSize:    123,
+		Metadata: &UploadMetadata{
+			DeleteKey: "abc123",
+			Expiry:    neverExpire,
+		},
+	}
+
+	if err := testTools.WriteUploadResponse(rr, 200, file); err != nil {
+		t.Fatal(err)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{`"filename":"foo.txt"`, `"url":"http://example.com/foo.txt"`, `"delete_key":"abc123"`, `"expiry":"never"`, `"size":123`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected response to contain %s, got %s", want, body)
+		}
+	}
+}